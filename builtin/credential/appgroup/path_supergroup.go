@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/helper/cidrutil"
 	"github.com/hashicorp/vault/helper/policyutil"
 	"github.com/hashicorp/vault/helper/strutil"
 	"github.com/hashicorp/vault/logical"
@@ -46,6 +47,13 @@ type superGroupStorageEntry struct {
 	// list will be added to capabilities of the token issued, when a SecretID generated
 	// in superGroup mode is used perform the login.
 	AdditionalPolicies []string `json:"additional_policies" structs:"additional_policies" mapstructure:"additional_policies"`
+
+	// A constraint, if set, restricts login using the SecretID generated by this
+	// supergroup to a set of allowed CIDR blocks
+	BoundCIDRList []string `json:"bound_cidr_list" structs:"bound_cidr_list" mapstructure:"bound_cidr_list"`
+
+	// A constraint, if set, restricts the issued token to a set of allowed CIDR blocks
+	TokenBoundCIDRs []string `json:"token_bound_cidrs" structs:"token_bound_cidrs" mapstructure:"token_bound_cidrs"`
 }
 
 // superGroupPaths creates the paths that are used to create SecretIDs in superGroup mode
@@ -53,6 +61,10 @@ type superGroupStorageEntry struct {
 // Paths returned:
 // supergroup/secret-id
 // supergroup/custom-secret-id
+// supergroup/secret-id/lookup
+// supergroup/secret-id/destroy
+// supergroup/login
+// supergroup/
 func superGroupPaths(b *backend) []*framework.Path {
 	return []*framework.Path{
 		&framework.Path{
@@ -97,6 +109,16 @@ addition to those, a set of policies can be assigned using this.
 					Type:        framework.TypeDurationSecond,
 					Description: "Duration in seconds after which the issued token should not be allowed to be renewed.",
 				},
+				"bound_cidr_list": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Default:     "",
+					Description: "Comma separated list of CIDR blocks, if set, specifies the blocks of IP addresses which can perform the login operation using the SecretID issued.",
+				},
+				"token_bound_cidrs": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Default:     "",
+					Description: "Comma separated list of CIDR blocks, if set, restricts the issued token to the set of allowed CIDR blocks.",
+				},
 			},
 
 			Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -148,6 +170,16 @@ addition to those, a set of policies can be assigned using this.
 					Type:        framework.TypeDurationSecond,
 					Description: "Duration in seconds after which the issued token should not be allowed to be renewed.",
 				},
+				"bound_cidr_list": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Default:     "",
+					Description: "Comma separated list of CIDR blocks, if set, specifies the blocks of IP addresses which can perform the login operation using the SecretID issued.",
+				},
+				"token_bound_cidrs": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Default:     "",
+					Description: "Comma separated list of CIDR blocks, if set, restricts the issued token to the set of allowed CIDR blocks.",
+				},
 			},
 			Callbacks: map[logical.Operation]framework.OperationFunc{
 				logical.UpdateOperation: b.pathSuperGroupCustomSecretIDUpdate,
@@ -155,6 +187,57 @@ addition to those, a set of policies can be assigned using this.
 			HelpSynopsis:    pathSuperGroupCustomSecretIDHelpSys,
 			HelpDescription: pathSuperGroupCustomSecretIDHelpDesc,
 		},
+		&framework.Path{
+			Pattern: "supergroup/secret-id/lookup$",
+			Fields: map[string]*framework.FieldSchema{
+				"secret_id": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: "SecretID of the supergroup, or its salted name as returned by the LIST endpoint.",
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathSuperGroupSecretIDLookupUpdate,
+			},
+			HelpSynopsis:    pathSuperGroupSecretIDLookupHelpSys,
+			HelpDescription: pathSuperGroupSecretIDLookupHelpDesc,
+		},
+		&framework.Path{
+			Pattern: "supergroup/secret-id/destroy$",
+			Fields: map[string]*framework.FieldSchema{
+				"secret_id": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: "SecretID of the supergroup, or its salted name as returned by the LIST endpoint.",
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathSuperGroupSecretIDDestroyUpdate,
+			},
+			HelpSynopsis:    pathSuperGroupSecretIDDestroyHelpSys,
+			HelpDescription: pathSuperGroupSecretIDDestroyHelpDesc,
+		},
+		&framework.Path{
+			Pattern: "supergroup/login$",
+			Fields: map[string]*framework.FieldSchema{
+				"secret_id": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: "SecretID of the supergroup.",
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathSuperGroupLoginUpdate,
+			},
+			HelpSynopsis:    pathSuperGroupLoginHelpSys,
+			HelpDescription: pathSuperGroupLoginHelpDesc,
+		},
+		&framework.Path{
+			Pattern: "supergroup/?$",
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: b.pathSuperGroupList,
+			},
+			HelpSynopsis:    pathSuperGroupListHelpSys,
+			HelpDescription: pathSuperGroupListHelpDesc,
+		},
 	}
 }
 
@@ -217,6 +300,280 @@ func (b *backend) superGroupEntry(s logical.Storage, superGroupName string) (*su
 	return &result, nil
 }
 
+// validateSuperGroupSecretIDBoundCIDRs enforces the 'bound_cidr_list' constraint
+// recorded on a supergroup entry. It is consulted during login, once the
+// superGroupStorageEntry for the presented SecretID has been resolved, using the
+// client's address off of req.Connection.RemoteAddr.
+func (b *backend) validateSuperGroupSecretIDBoundCIDRs(superGroup *superGroupStorageEntry, remoteAddr string) error {
+	if len(superGroup.BoundCIDRList) == 0 {
+		return nil
+	}
+	if remoteAddr == "" {
+		return fmt.Errorf("failed to verify bound CIDR restriction: missing remote address in request")
+	}
+
+	belongs, err := cidrutil.IPBelongsToCIDRList(remoteAddr, superGroup.BoundCIDRList)
+	if err != nil {
+		return fmt.Errorf("failed to verify bound CIDR restriction: %s", err)
+	}
+	if !belongs {
+		return fmt.Errorf("source address %q unauthorized through CIDR restrictions on the SecretID", remoteAddr)
+	}
+
+	return nil
+}
+
+// parseAndValidateCIDRField parses a comma separated list of CIDR blocks
+// submitted under fieldName and validates each entry, returning an error
+// response for the caller to surface on malformed input.
+func parseAndValidateCIDRField(cidrListStr, fieldName string) ([]string, *logical.Response, error) {
+	cidrList := strutil.ParseStrings(cidrListStr)
+	if len(cidrList) == 0 {
+		return cidrList, nil, nil
+	}
+
+	if valid, err := cidrutil.ValidateCIDRListString(cidrListStr, ","); err != nil {
+		return nil, nil, fmt.Errorf("failed to validate %s: %s", fieldName, err)
+	} else if !valid {
+		return nil, logical.ErrorResponse(fmt.Sprintf("invalid CIDR blocks in %s", fieldName)), nil
+	}
+
+	return cidrList, nil, nil
+}
+
+// superGroupEntryByID resolves a supergroup SecretID, accepting either the
+// raw 'secret_id' presented at login or the salted name it is stored under.
+// It returns the salted name alongside the entry so that callers which need
+// to mutate storage don't have to re-derive it.
+func (b *backend) superGroupEntryByID(s logical.Storage, secretID string) (string, *superGroupStorageEntry, error) {
+	superGroupName := strings.ToLower(secretID)
+	entry, err := b.superGroupEntry(s, superGroupName)
+	if err != nil {
+		return "", nil, err
+	}
+	if entry != nil {
+		return superGroupName, entry, nil
+	}
+
+	superGroupName = b.salt.SaltID(secretID)
+	entry, err = b.superGroupEntry(s, superGroupName)
+	if err != nil {
+		return "", nil, err
+	}
+	return superGroupName, entry, nil
+}
+
+// deleteSuperGroupSecretID removes the supergroup entry along with its
+// associated secretIDStorageEntry and selectorIDStorageEntry while holding the
+// write lock. The deletes run one after another; if one fails, the deletes
+// before it are not undone.
+func (b *backend) deleteSuperGroupSecretID(s logical.Storage, superGroupName string, superGroup *superGroupStorageEntry) error {
+	b.superGroupLock.Lock()
+	defer b.superGroupLock.Unlock()
+
+	if err := s.Delete("supergroup/" + superGroupName); err != nil {
+		return err
+	}
+	if err := b.deleteSecretIDEntry(s, superGroup.SelectorID); err != nil {
+		return err
+	}
+	return b.deleteSelectorIDEntry(s, superGroup.SelectorID)
+}
+
+// Path to list the supergroups for which SecretIDs have been issued.
+func (b *backend) pathSuperGroupList(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.superGroupLock.RLock()
+	defer b.superGroupLock.RUnlock()
+
+	names, err := req.Storage.List("supergroup/")
+	if err != nil {
+		return nil, err
+	}
+
+	keyInfo := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		raw, err := req.Storage.Get("supergroup/" + name)
+		if err != nil {
+			return nil, err
+		}
+		if raw == nil {
+			continue
+		}
+		var entry superGroupStorageEntry
+		if err := raw.DecodeJSON(&entry); err != nil {
+			return nil, err
+		}
+
+		info := map[string]interface{}{
+			"selector_id":         entry.SelectorID,
+			"groups":              entry.Groups,
+			"apps":                entry.Apps,
+			"additional_policies": entry.AdditionalPolicies,
+			"secret_id_ttl":       entry.SecretIDTTL / time.Second,
+			"bound_cidr_list":     entry.BoundCIDRList,
+			"token_bound_cidrs":   entry.TokenBoundCIDRs,
+		}
+		secretIDEntry, err := b.secretIDEntry(req.Storage, entry.SelectorID)
+		if err != nil {
+			return nil, err
+		}
+		if secretIDEntry != nil {
+			info["secret_id_num_uses"] = secretIDEntry.SecretIDNumUses
+		}
+		keyInfo[name] = info
+	}
+
+	resp := logical.ListResponse(names)
+	resp.Data["key_info"] = keyInfo
+	return resp, nil
+}
+
+// Path to look up the options set on a supergroup SecretID without
+// consuming one of its uses.
+func (b *backend) pathSuperGroupSecretIDLookupUpdate(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	secretID := data.Get("secret_id").(string)
+	if secretID == "" {
+		return logical.ErrorResponse("missing secret_id"), nil
+	}
+
+	_, superGroup, err := b.superGroupEntryByID(req.Storage, secretID)
+	if err != nil {
+		return nil, err
+	}
+	if superGroup == nil {
+		return logical.ErrorResponse("failed to find supergroup SecretID"), nil
+	}
+
+	respData := map[string]interface{}{
+		"selector_id":         superGroup.SelectorID,
+		"groups":              superGroup.Groups,
+		"apps":                superGroup.Apps,
+		"additional_policies": superGroup.AdditionalPolicies,
+		"secret_id_ttl":       superGroup.SecretIDTTL / time.Second,
+		"bound_cidr_list":     superGroup.BoundCIDRList,
+		"token_bound_cidrs":   superGroup.TokenBoundCIDRs,
+	}
+
+	secretIDEntry, err := b.secretIDEntry(req.Storage, superGroup.SelectorID)
+	if err != nil {
+		return nil, err
+	}
+	if secretIDEntry != nil {
+		respData["secret_id_num_uses"] = secretIDEntry.SecretIDNumUses
+	}
+
+	return &logical.Response{Data: respData}, nil
+}
+
+// Path to revoke a supergroup SecretID, tearing down the supergroup entry
+// and its associated secretIDStorageEntry and selectorIDStorageEntry.
+func (b *backend) pathSuperGroupSecretIDDestroyUpdate(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	secretID := data.Get("secret_id").(string)
+	if secretID == "" {
+		return logical.ErrorResponse("missing secret_id"), nil
+	}
+
+	superGroupName, superGroup, err := b.superGroupEntryByID(req.Storage, secretID)
+	if err != nil {
+		return nil, err
+	}
+	if superGroup == nil {
+		return logical.ErrorResponse("failed to find supergroup SecretID"), nil
+	}
+
+	if err := b.deleteSuperGroupSecretID(req.Storage, superGroupName, superGroup); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// consumeSuperGroupSecretID enforces 'secret_id_num_uses' and 'secret_id_ttl'
+// on the secretIDStorageEntry backing a supergroup SecretID at login time,
+// the same lifecycle that bind_secret_id implies for every other mode. The
+// entry is deleted once its uses are exhausted or its TTL has elapsed, so
+// that a subsequent login attempt fails.
+func (b *backend) consumeSuperGroupSecretID(s logical.Storage, secretID string, superGroup *superGroupStorageEntry) error {
+	secretIDEntry, err := b.secretIDEntry(s, superGroup.SelectorID)
+	if err != nil {
+		return err
+	}
+	if secretIDEntry == nil {
+		return fmt.Errorf("failed to find secret_id")
+	}
+
+	if secretIDEntry.SecretIDTTL > time.Duration(0) &&
+		time.Now().After(secretIDEntry.CreationTime.Add(secretIDEntry.SecretIDTTL)) {
+		if err := b.deleteSecretIDEntry(s, superGroup.SelectorID); err != nil {
+			return err
+		}
+		return fmt.Errorf("secret_id has expired")
+	}
+
+	// A zero value means the SecretID carries no limit on its uses.
+	if secretIDEntry.SecretIDNumUses == 0 {
+		return nil
+	}
+
+	secretIDEntry.SecretIDNumUses--
+	if secretIDEntry.SecretIDNumUses == 0 {
+		return b.deleteSecretIDEntry(s, superGroup.SelectorID)
+	}
+
+	return b.registerSecretIDEntry(s, superGroup.SelectorID, secretID, superGroup.HMACKey, secretIDEntry)
+}
+
+// Path to authenticate against a supergroup using a previously issued
+// SecretID. Unlike the lookup/destroy endpoints, login resolves strictly
+// through the salted name of the presented 'secret_id' -- the salted name
+// itself, as returned by the LIST on 'supergroup/', must never be accepted
+// here, or bind_secret_id would be defeated for anyone who can list. The
+// source address is checked against 'bound_cidr_list' before a token is
+// issued, the SecretID's remaining uses and TTL are consumed, and
+// 'token_bound_cidrs' is carried onto the returned Auth so that renewals
+// continue to enforce the same restriction.
+func (b *backend) pathSuperGroupLoginUpdate(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	secretID := data.Get("secret_id").(string)
+	if secretID == "" {
+		return logical.ErrorResponse("missing secret_id"), nil
+	}
+
+	superGroup, err := b.superGroupEntry(req.Storage, b.salt.SaltID(secretID))
+	if err != nil {
+		return nil, err
+	}
+	if superGroup == nil {
+		return logical.ErrorResponse("failed to find supergroup SecretID"), nil
+	}
+
+	var remoteAddr string
+	if req.Connection != nil {
+		remoteAddr = req.Connection.RemoteAddr
+	}
+	if err := b.validateSuperGroupSecretIDBoundCIDRs(superGroup, remoteAddr); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if err := b.consumeSuperGroupSecretID(req.Storage, secretID, superGroup); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	return &logical.Response{
+		Auth: &logical.Auth{
+			Policies: superGroup.AdditionalPolicies,
+			Metadata: map[string]string{
+				"selector_id": superGroup.SelectorID,
+			},
+			LeaseOptions: logical.LeaseOptions{
+				TTL:       superGroup.TokenTTL,
+				MaxTTL:    superGroup.TokenMaxTTL,
+				Renewable: true,
+			},
+			BoundCIDRs: superGroup.TokenBoundCIDRs,
+		},
+	}, nil
+}
+
 // Path to issue a 'secret_id' on the supergroup
 func (b *backend) pathSuperGroupSecretIDUpdate(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	secretID, err := uuid.GenerateUUID()
@@ -246,6 +603,16 @@ func (b *backend) handleSuperGroupSecretIDCommon(req *logical.Request, data *fra
 	if err != nil {
 		return nil, fmt.Errorf("failed to create hmac_key: %s\n", err)
 	}
+	boundCIDRList, errResp, err := parseAndValidateCIDRField(data.Get("bound_cidr_list").(string), "bound_cidr_list")
+	if err != nil || errResp != nil {
+		return errResp, err
+	}
+
+	tokenBoundCIDRs, errResp, err := parseAndValidateCIDRField(data.Get("token_bound_cidrs").(string), "token_bound_cidrs")
+	if err != nil || errResp != nil {
+		return errResp, err
+	}
+
 	superGroup := &superGroupStorageEntry{
 		SelectorID:         selectorID,
 		HMACKey:            hmacKey,
@@ -257,6 +624,8 @@ func (b *backend) handleSuperGroupSecretIDCommon(req *logical.Request, data *fra
 		SecretIDTTL:        time.Second * time.Duration(data.Get("secret_id_ttl").(int)),
 		TokenTTL:           time.Second * time.Duration(data.Get("token_ttl").(int)),
 		TokenMaxTTL:        time.Second * time.Duration(data.Get("token_max_ttl").(int)),
+		BoundCIDRList:      boundCIDRList,
+		TokenBoundCIDRs:    tokenBoundCIDRs,
 	}
 
 	if len(superGroup.Groups) == 0 && len(superGroup.Apps) == 0 {
@@ -271,9 +640,8 @@ func (b *backend) handleSuperGroupSecretIDCommon(req *logical.Request, data *fra
 		return logical.ErrorResponse("token_ttl should not be greater than token_max_ttl"), nil
 	}
 
-	var resp *logical.Response
-	if supergroup.TokenMaxTTL > b.System().MaxLeaseTTL() {
-		resp = &logical.Response{}
+	resp := &logical.Response{}
+	if superGroup.TokenMaxTTL > b.System().MaxLeaseTTL() {
 		resp.AddWarning("token_max_ttl is greater than the backend mount's maximum TTL value; issued tokens' max TTL value will be truncated")
 	}
 
@@ -332,3 +700,33 @@ specified Groups. The options specified on this endpoint will supercede
 all the options set on App(s)/Group(s). The SecretIDs generated will expire
 after a period defined by the 'secret_id_ttl' option and/or the backend
 mount's maximum TTL value.`
+
+const pathSuperGroupSecretIDLookupHelpSys = `Read the properties of a supergroup SecretID.`
+
+const pathSuperGroupSecretIDLookupHelpDesc = `This endpoint accepts either the SecretID
+issued against a supergroup, or the salted name it is stored under (as returned
+by the LIST on 'supergroup/'), and returns the SelectorID, Groups, Apps,
+AdditionalPolicies, remaining uses and TTL recorded for it.`
+
+const pathSuperGroupSecretIDDestroyHelpSys = `Revoke a supergroup SecretID.`
+
+const pathSuperGroupSecretIDDestroyHelpDesc = `This endpoint accepts either the SecretID
+issued against a supergroup, or the salted name it is stored under (as returned
+by the LIST on 'supergroup/'), and deletes the supergroup entry along with the
+SecretID and SelectorID storage associated with it.`
+
+const pathSuperGroupLoginHelpSys = `Authenticate using a SecretID issued against a supergroup.`
+
+const pathSuperGroupLoginHelpDesc = `This endpoint accepts the SecretID issued against a
+supergroup and, if the request's source address satisfies any 'bound_cidr_list'
+recorded on it, issues a token carrying the supergroup's AdditionalPolicies,
+consuming one of the SecretID's remaining uses. The policies of the
+supergroup's Apps and Groups are not added to the token. If 'token_bound_cidrs' was set
+on the supergroup, it is attached to the issued token so that renewals
+continue to enforce the same source-address restriction.`
+
+const pathSuperGroupListHelpSys = `List the supergroup SecretIDs that have been issued.`
+
+const pathSuperGroupListHelpDesc = `Lists the salted names under which supergroup
+SecretIDs are stored, along with the SelectorID, Groups, Apps, AdditionalPolicies,
+remaining uses and TTL recorded against each.`
@@ -0,0 +1,245 @@
+package appgroup
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func createBackendWithStorage(t *testing.T) (*backend, logical.Storage) {
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+
+	b, err := Backend(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b == nil {
+		t.Fatalf("failed to create backend")
+	}
+	if err := b.Setup(config); err != nil {
+		t.Fatal(err)
+	}
+	return b, config.StorageView
+}
+
+func TestSuperGroup_SecretIDLookupDestroyList(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "supergroup/secret-id",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"groups": "group1",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err: %v resp: %#v", err, resp)
+	}
+	secretID := resp.Data["secret_id"].(string)
+	if secretID == "" {
+		t.Fatalf("expected a secret_id to be returned")
+	}
+
+	resp, err = b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "supergroup/secret-id/lookup",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"secret_id": secretID,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err: %v resp: %#v", err, resp)
+	}
+	if resp.Data["selector_id"].(string) == "" {
+		t.Fatalf("expected a selector_id in the lookup response")
+	}
+
+	resp, err = b.HandleRequest(&logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "supergroup/",
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err: %v resp: %#v", err, resp)
+	}
+	keys := resp.Data["keys"].([]string)
+	if len(keys) != 1 {
+		t.Fatalf("expected one supergroup entry, got %d", len(keys))
+	}
+
+	resp, err = b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "supergroup/secret-id/destroy",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"secret_id": secretID,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err: %v resp: %#v", err, resp)
+	}
+
+	resp, err = b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "supergroup/secret-id/lookup",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"secret_id": secretID,
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected lookup to fail after destroy, got resp: %#v", resp)
+	}
+}
+
+func TestSuperGroup_BoundCIDRLogin(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "supergroup/secret-id",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"groups":            "group1",
+			"bound_cidr_list":   "127.0.0.1/32",
+			"token_bound_cidrs": "127.0.0.1/32",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err: %v resp: %#v", err, resp)
+	}
+	secretID := resp.Data["secret_id"].(string)
+
+	resp, err = b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "supergroup/login",
+		Storage:   storage,
+		Connection: &logical.Connection{
+			RemoteAddr: "10.0.0.5",
+		},
+		Data: map[string]interface{}{
+			"secret_id": secretID,
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected login from an out-of-CIDR address to be rejected, got resp: %#v", resp)
+	}
+
+	resp, err = b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "supergroup/login",
+		Storage:   storage,
+		Connection: &logical.Connection{
+			RemoteAddr: "127.0.0.1",
+		},
+		Data: map[string]interface{}{
+			"secret_id": secretID,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err: %v resp: %#v", err, resp)
+	}
+	if resp.Auth == nil {
+		t.Fatalf("expected auth to be returned on successful login")
+	}
+	if len(resp.Auth.BoundCIDRs) != 1 || resp.Auth.BoundCIDRs[0] != "127.0.0.1/32" {
+		t.Fatalf("expected token_bound_cidrs to be carried onto the issued token, got: %#v", resp.Auth.BoundCIDRs)
+	}
+}
+
+func TestSuperGroup_LoginRejectsSaltedName(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "supergroup/secret-id",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"groups": "group1",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err: %v resp: %#v", err, resp)
+	}
+
+	resp, err = b.HandleRequest(&logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "supergroup/",
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err: %v resp: %#v", err, resp)
+	}
+	saltedName := resp.Data["keys"].([]string)[0]
+
+	resp, err = b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "supergroup/login",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"secret_id": saltedName,
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected login with the salted name returned by LIST to be rejected, got resp: %#v", resp)
+	}
+}
+
+func TestSuperGroup_LoginConsumesSecretIDNumUses(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "supergroup/secret-id",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"groups":             "group1",
+			"secret_id_num_uses": 2,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err: %v resp: %#v", err, resp)
+	}
+	secretID := resp.Data["secret_id"].(string)
+
+	for i := 0; i < 2; i++ {
+		resp, err = b.HandleRequest(&logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "supergroup/login",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"secret_id": secretID,
+			},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("login %d: err: %v resp: %#v", i, err, resp)
+		}
+	}
+
+	resp, err = b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "supergroup/login",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"secret_id": secretID,
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected login to fail once secret_id_num_uses is exhausted, got resp: %#v", resp)
+	}
+}